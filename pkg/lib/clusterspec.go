@@ -16,20 +16,20 @@
 package lib
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	log "github.com/sirupsen/logrus"
 	"net/http"
 	"path/filepath"
 	"strings"
 
 	"github.com/spf13/afero"
-)
-
-const (
-	k8sVersionURLTemplate = "https://raw.githubusercontent.com/kubernetes/kubernetes/%s/api/openapi-spec/swagger.json"
+	apiextensionsclientset "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/homedir"
 )
 
 // ClusterSpec represents the API supported by some cluster. There are several
@@ -40,21 +40,63 @@ type ClusterSpec interface {
 	OpenAPI() ([]byte, error)
 	Resource() string // For testing parsing logic.
 	Version() (string, error)
+
+	// CRDSchemas returns the OpenAPI documents, if any, synthesized from
+	// CustomResourceDefinitions installed in the cluster this spec describes.
+	// Modes that have no notion of a live cluster (e.g. a pinned k8s version
+	// or a static file) always return a nil slice.
+	CRDSchemas() ([][]byte, error)
+}
+
+// ClusterSpecOpt configures optional, non-identifying behavior of a
+// ClusterSpec produced by ParseClusterSpec (e.g. bypassing an on-disk cache).
+type ClusterSpecOpt func(*clusterSpecOpts)
+
+type clusterSpecOpts struct {
+	refresh bool
+}
+
+// WithRefresh makes a `version:` ClusterSpec ignore its on-disk cache and
+// refetch the schema from the origin without conditional headers, analogous
+// to a `--refresh` CLI flag. Other spec kinds ignore it; in particular a
+// `v3url:` spec still reads its cached merge.
+func WithRefresh(refresh bool) ClusterSpecOpt {
+	return func(o *clusterSpecOpts) {
+		o.refresh = refresh
+	}
 }
 
 // ParseClusterSpec will parse a cluster spec flag and output a well-formed
 // ClusterSpec object. For example, if the flag is `--version:v1.7.1`, then we
 // will output a ClusterSpec representing the cluster specification associated
 // with the `v1.7.1` build of Kubernetes.
-func ParseClusterSpec(specFlag string, fs afero.Fs, httpClient *http.Client) (ClusterSpec, error) {
+func ParseClusterSpec(specFlag string, fs afero.Fs, httpClient *http.Client, opts ...ClusterSpecOpt) (ClusterSpec, error) {
+	var o clusterSpecOpts
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	split := strings.SplitN(specFlag, ":", 2)
-	if len(split) <= 1 || split[1] == "" {
+	if len(split) <= 1 {
+		return nil, fmt.Errorf("Invalid API specification '%s'", specFlag)
+	}
+	// kubeconfig is the only mode with a meaningful empty value: it defaults
+	// to ~/.kube/config (see parseKubeconfigSpec), so `kubeconfig:` alone is
+	// a valid spec.
+	if split[1] == "" && split[0] != "kubeconfig" {
 		return nil, fmt.Errorf("Invalid API specification '%s'", specFlag)
 	}
 
 	switch split[0] {
 	case "version":
-		return &clusterSpecVersion{k8sVersion: split[1], httpClient: httpClient}, nil
+		version, mirrors, checksum := parseVersionSpec(split[1])
+		return &clusterSpecVersion{
+			k8sVersion: version,
+			httpClient: httpClient,
+			fs:         fs,
+			refresh:    o.refresh,
+			resolver:   newSchemaResolver(mirrors, fs, checksum),
+		}, nil
 	case "file":
 		p, err := filepath.Abs(split[1])
 		if err != nil {
@@ -63,6 +105,15 @@ func ParseClusterSpec(specFlag string, fs afero.Fs, httpClient *http.Client) (Cl
 		return &clusterSpecFile{specPath: p, fs: fs}, nil
 	case "url":
 		return &clusterSpecLive{apiServerURL: split[1]}, nil
+	case "kubeconfig":
+		path, kubeContext := parseKubeconfigSpec(split[1])
+		return &clusterSpecLive{kubeconfigPath: path, kubeconfigContext: kubeContext}, nil
+	case "v3url":
+		return &clusterSpecV3{serverURL: split[1], httpClient: httpClient, fs: fs}, nil
+	case "v3":
+		return &clusterSpecV3{k8sVersion: split[1], httpClient: httpClient, fs: fs}, nil
+	case "registry":
+		return &clusterSpecRegistry{ref: split[1], fs: fs}, nil
 	default:
 		return nil, fmt.Errorf("Could not parse cluster spec '%s'", specFlag)
 	}
@@ -81,6 +132,12 @@ func (cs *clusterSpecFile) Resource() string {
 	return string(cs.specPath)
 }
 
+// CRDSchemas always returns no schemas: a file spec has no cluster to list
+// CRDs from.
+func (cs *clusterSpecFile) CRDSchemas() ([][]byte, error) {
+	return nil, nil
+}
+
 func (cs *clusterSpecFile) Version() (string, error) {
 	//
 	// Condensed representation of the spec file, containing the minimal
@@ -107,81 +164,130 @@ func (cs *clusterSpecFile) Version() (string, error) {
 	return spec.Info.Version, nil
 }
 
-type clusterSpecLive struct {
-	apiServerURL string
+// parseKubeconfigSpec splits a `kubeconfig:<path>[@context]` spec value into
+// its path and optional context name. If no path is given, the default
+// kubeconfig location (`~/.kube/config`) is used.
+func parseKubeconfigSpec(value string) (path, kubeContext string) {
+	path = value
+	if idx := strings.LastIndex(value, "@"); idx != -1 {
+		path, kubeContext = value[:idx], value[idx+1:]
+	}
+	if path == "" {
+		path = filepath.Join(homedir.HomeDir(), ".kube", "config")
+	}
+	return path, kubeContext
 }
 
-func (cs *clusterSpecLive) OpenAPI() ([]byte, error) {
-	return nil, fmt.Errorf("Initializing from OpenAPI spec in live cluster is not implemented")
-}
+type clusterSpecLive struct {
+	apiServerURL      string
+	kubeconfigPath    string
+	kubeconfigContext string
 
-func (cs *clusterSpecLive) Resource() string {
-	return string(cs.apiServerURL)
+	// discoveryClientOverride and crdClientOverride let tests substitute fake
+	// implementations of the live-cluster clients without a real kubeconfig
+	// or API server. Production code always leaves these nil, in which case
+	// discoveryClient() and crdClient() build real clients from restConfig().
+	discoveryClientOverride discovery.DiscoveryInterface
+	crdClientOverride       apiextensionsclientset.Interface
 }
 
-func (cs *clusterSpecLive) Version() (string, error) {
-	return "", fmt.Errorf("Retrieving version spec in live cluster is not implemented")
+// restConfig builds an authenticated rest.Config for talking to the cluster,
+// either from an explicit kubeconfig (with an optional context override) or,
+// failing that, from a bare API server URL.
+func (cs *clusterSpecLive) restConfig() (*rest.Config, error) {
+	if cs.kubeconfigPath != "" {
+		loadingRules := &clientcmd.ClientConfigLoadingRules{ExplicitPath: cs.kubeconfigPath}
+		overrides := &clientcmd.ConfigOverrides{}
+		if cs.kubeconfigContext != "" {
+			overrides.CurrentContext = cs.kubeconfigContext
+		}
+		config := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides)
+		return config.ClientConfig()
+	}
+
+	if cs.apiServerURL != "" {
+		return &rest.Config{Host: cs.apiServerURL}, nil
+	}
+
+	return nil, errors.New("no kubeconfig or API server URL was provided for the live cluster spec")
 }
 
-type clusterSpecVersion struct {
-	k8sVersion string
-	httpClient *http.Client
+func (cs *clusterSpecLive) discoveryClient() (discovery.DiscoveryInterface, error) {
+	if cs.discoveryClientOverride != nil {
+		return cs.discoveryClientOverride, nil
+	}
+
+	config, err := cs.restConfig()
+	if err != nil {
+		return nil, err
+	}
+	return discovery.NewDiscoveryClientForConfig(config)
 }
 
-func (cs *clusterSpecVersion) attemptToGetSchema(version string) ([]byte, error) {
-	versionURL := fmt.Sprintf(k8sVersionURLTemplate, version)
-	resp, err := cs.httpClient.Get(versionURL)
+func (cs *clusterSpecLive) OpenAPI() ([]byte, error) {
+	client, err := cs.discoveryClient()
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		log.Warningf("received status code '%d' when attempting to retrieve OpenAPI schema for cluster "+
-			"version '%s' from URL '%s'", resp.StatusCode, version, versionURL)
-		return nil, nil
+	schema, err := client.RESTClient().Get().AbsPath("/openapi/v2").DoRaw(context.TODO())
+	if err != nil {
+		return nil, fmt.Errorf("fetching OpenAPI schema from live cluster: %v", err)
 	}
-	return ioutil.ReadAll(resp.Body)
+
+	return schema, nil
 }
 
-func (cs *clusterSpecVersion) OpenAPI() ([]byte, error) {
-	if cs.httpClient == nil {
-		return nil, errors.New("nil httpClient")
+func (cs *clusterSpecLive) Resource() string {
+	if cs.kubeconfigPath != "" {
+		if cs.kubeconfigContext != "" {
+			return fmt.Sprintf("%s@%s", cs.kubeconfigPath, cs.kubeconfigContext)
+		}
+		return string(cs.kubeconfigPath)
 	}
+	return string(cs.apiServerURL)
+}
 
-	schema, err := cs.attemptToGetSchema(cs.k8sVersion)
-
+func (cs *clusterSpecLive) Version() (string, error) {
+	client, err := cs.discoveryClient()
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	if schema == nil {
-		// try again with a release version, e.g., for v1.11.7,
-		// the release version tag should be release-1.11
-		segments := strings.Split(strings.Replace(cs.k8sVersion, "v", "", 1), ".")
-		if len(segments) >= 2 {
-			releaseVersion := "release-" + strings.Join(segments[0:2], ".")
-			schema, err = cs.attemptToGetSchema(releaseVersion)
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			return nil, fmt.Errorf("unrecognizable k8s version '%s'", cs.k8sVersion)
-		}
-		// TODO: handle other corner cases
+	serverVersion, err := client.ServerVersion()
+	if err != nil {
+		return "", fmt.Errorf("retrieving version from live cluster: %v", err)
 	}
 
-	if schema == nil {
-		return nil, fmt.Errorf("unable to fetch OpenAPI schema")
+	return serverVersion.GitVersion, nil
+}
+
+type clusterSpecVersion struct {
+	k8sVersion string
+	httpClient *http.Client
+	fs         afero.Fs
+	refresh    bool
+	resolver   *schemaResolver
+}
+
+func (cs *clusterSpecVersion) OpenAPI() ([]byte, error) {
+	if cs.httpClient == nil {
+		return nil, errors.New("nil httpClient")
 	}
 
-	return schema, err
+	return cs.resolver.Resolve(cs.httpClient, cs.fs, cs.k8sVersion, cs.refresh)
 }
 
 func (cs *clusterSpecVersion) Resource() string {
 	return string(cs.k8sVersion)
 }
 
+// CRDSchemas always returns no schemas: a pinned k8s version has no cluster
+// to list CRDs from.
+func (cs *clusterSpecVersion) CRDSchemas() ([][]byte, error) {
+	return nil, nil
+}
+
 func (cs *clusterSpecVersion) Version() (string, error) {
 	return string(cs.k8sVersion), nil
 }
@@ -0,0 +1,148 @@
+// Copyright 2017 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestFetchHTTPSchemaRevalidatesViaETag(t *testing.T) {
+	const etag = `"v1"`
+	requests := 0
+	var lastIfNoneMatch string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		lastIfNoneMatch = r.Header.Get("If-None-Match")
+		if lastIfNoneMatch == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("swagger-content"))
+	}))
+	defer server.Close()
+
+	fs := afero.NewMemMapFs()
+	client := server.Client()
+
+	body, err := fetchHTTPSchema(client, fs, server.URL, false)
+	if err != nil {
+		t.Fatalf("first fetch: unexpected error: %v", err)
+	}
+	if string(body) != "swagger-content" {
+		t.Fatalf("first fetch: got %q, want %q", body, "swagger-content")
+	}
+	if requests != 1 {
+		t.Fatalf("expected 1 request after first fetch, got %d", requests)
+	}
+
+	body, err = fetchHTTPSchema(client, fs, server.URL, false)
+	if err != nil {
+		t.Fatalf("second fetch: unexpected error: %v", err)
+	}
+	if string(body) != "swagger-content" {
+		t.Fatalf("second fetch (304): got %q, want %q", body, "swagger-content")
+	}
+	if requests != 2 {
+		t.Fatalf("expected 2 requests after second fetch, got %d", requests)
+	}
+	if lastIfNoneMatch != etag {
+		t.Fatalf("second fetch should have sent If-None-Match: %q, got %q", etag, lastIfNoneMatch)
+	}
+}
+
+func TestFetchHTTPSchemaRefreshBypassesConditionalRequest(t *testing.T) {
+	const etag = `"v1"`
+	var lastIfNoneMatch string
+	sawIfNoneMatchHeader := false
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastIfNoneMatch = r.Header.Get("If-None-Match")
+		if lastIfNoneMatch != "" {
+			sawIfNoneMatchHeader = true
+		}
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("swagger-content"))
+	}))
+	defer server.Close()
+
+	fs := afero.NewMemMapFs()
+	client := server.Client()
+
+	if _, err := fetchHTTPSchema(client, fs, server.URL, false); err != nil {
+		t.Fatalf("seeding cache: unexpected error: %v", err)
+	}
+
+	if _, err := fetchHTTPSchema(client, fs, server.URL, true); err != nil {
+		t.Fatalf("refresh fetch: unexpected error: %v", err)
+	}
+	if sawIfNoneMatchHeader {
+		t.Fatalf("refresh=true should bypass the cache and not send If-None-Match")
+	}
+}
+
+func TestFetchHTTPSchemaTTLExpiryForcesUnconditionalRefetch(t *testing.T) {
+	const etag = `"v1"`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("swagger-content"))
+	}))
+	defer server.Close()
+
+	fs := afero.NewMemMapFs()
+	client := server.Client()
+
+	if _, err := fetchHTTPSchema(client, fs, server.URL, false); err != nil {
+		t.Fatalf("seeding cache: unexpected error: %v", err)
+	}
+
+	// Backdate the cached metadata past the TTL so the next fetch should not
+	// even attempt a conditional request.
+	schemaPath, metaPath := schemaCachePaths(server.URL)
+	meta := readSchemaCacheMeta(fs, metaPath)
+	if meta == nil {
+		t.Fatal("expected cache metadata to have been written")
+	}
+	meta.FetchedAt = time.Now().Add(-(openAPICacheTTL + time.Hour))
+	writeSchemaCache(fs, schemaPath, metaPath, []byte("swagger-content"), *meta)
+
+	requestsAfterBackdate := 0
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestsAfterBackdate++
+		if r.Header.Get("If-None-Match") != "" {
+			t.Errorf("expected no conditional headers once the cache entry is past its TTL")
+		}
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("swagger-content"))
+	})
+
+	if _, err := fetchHTTPSchema(client, fs, server.URL, false); err != nil {
+		t.Fatalf("post-TTL fetch: unexpected error: %v", err)
+	}
+	if requestsAfterBackdate != 1 {
+		t.Fatalf("expected exactly 1 request after backdating the cache, got %d", requestsAfterBackdate)
+	}
+}
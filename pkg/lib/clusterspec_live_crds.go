@@ -0,0 +1,92 @@
+// Copyright 2017 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	apiextensionsclientset "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// crdClient returns the client used to list CustomResourceDefinitions,
+// defaulting to a real clientset built from restConfig() unless a test has
+// set crdClientOverride.
+func (cs *clusterSpecLive) crdClient() (apiextensionsclientset.Interface, error) {
+	if cs.crdClientOverride != nil {
+		return cs.crdClientOverride, nil
+	}
+
+	config, err := cs.restConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := apiextensionsclientset.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("building CustomResourceDefinition client: %v", err)
+	}
+	return client, nil
+}
+
+// CRDSchemas lists the CustomResourceDefinitions installed in the live
+// cluster and synthesizes one OpenAPI document per CRD, containing a
+// `definitions` entry for each version's `openAPIV3Schema`. A CRD none of
+// whose versions has an `openAPIV3Schema` yields no document. These documents
+// are not part of the upstream Kubernetes swagger.json and are meant to be
+// merged with the base spec returned by OpenAPI().
+func (cs *clusterSpecLive) CRDSchemas() ([][]byte, error) {
+	client, err := cs.crdClient()
+	if err != nil {
+		return nil, err
+	}
+
+	crds, err := client.ApiextensionsV1().CustomResourceDefinitions().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing CustomResourceDefinitions: %v", err)
+	}
+
+	var docs [][]byte
+	for _, crd := range crds.Items {
+		definitions := map[string]interface{}{}
+		for _, version := range crd.Spec.Versions {
+			if version.Schema == nil || version.Schema.OpenAPIV3Schema == nil {
+				continue
+			}
+
+			name := fmt.Sprintf("%s.%s.%s", crd.Spec.Group, version.Name, crd.Spec.Names.Kind)
+			definitions[name] = version.Schema.OpenAPIV3Schema
+		}
+
+		if len(definitions) == 0 {
+			continue
+		}
+
+		doc, err := json.Marshal(map[string]interface{}{
+			"swagger":     "2.0",
+			"definitions": definitions,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("marshaling synthetic OpenAPI document for CRD '%s': %v", crd.Name, err)
+		}
+
+		docs = append(docs, doc)
+	}
+
+	return docs, nil
+}
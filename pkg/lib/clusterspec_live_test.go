@@ -0,0 +1,132 @@
+// Copyright 2017 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lib
+
+import (
+	"encoding/json"
+	"testing"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apiextensionsfake "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	kubetesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/version"
+)
+
+func TestClusterSpecLiveRestConfigFromAPIServerURL(t *testing.T) {
+	cs := &clusterSpecLive{apiServerURL: "https://cluster.example.com"}
+
+	config, err := cs.restConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.Host != "https://cluster.example.com" {
+		t.Errorf("config.Host = %q, want %q", config.Host, "https://cluster.example.com")
+	}
+}
+
+func TestClusterSpecLiveRestConfigRequiresKubeconfigOrURL(t *testing.T) {
+	cs := &clusterSpecLive{}
+
+	if _, err := cs.restConfig(); err == nil {
+		t.Error("expected an error when neither a kubeconfig nor an API server URL is set")
+	}
+}
+
+func TestClusterSpecLiveVersion(t *testing.T) {
+	fakeDiscovery := &fakediscovery.FakeDiscovery{Fake: &kubetesting.Fake{}}
+	fakeDiscovery.FakedServerVersion = &version.Info{GitVersion: "v1.27.3"}
+
+	cs := &clusterSpecLive{
+		apiServerURL:            "https://cluster.example.com",
+		discoveryClientOverride: fakeDiscovery,
+	}
+
+	got, err := cs.Version()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "v1.27.3" {
+		t.Errorf("Version() = %q, want %q", got, "v1.27.3")
+	}
+}
+
+func TestClusterSpecLiveCRDSchemasSynthesizesDefinitions(t *testing.T) {
+	crd := &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: "widgets.example.com"},
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Group: "example.com",
+			Names: apiextensionsv1.CustomResourceDefinitionNames{Kind: "Widget"},
+			Versions: []apiextensionsv1.CustomResourceDefinitionVersion{
+				{
+					Name: "v1",
+					Schema: &apiextensionsv1.CustomResourceValidation{
+						OpenAPIV3Schema: &apiextensionsv1.JSONSchemaProps{Type: "object"},
+					},
+				},
+			},
+		},
+	}
+
+	cs := &clusterSpecLive{crdClientOverride: apiextensionsfake.NewSimpleClientset(crd)}
+
+	docs, err := cs.CRDSchemas()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(docs) != 1 {
+		t.Fatalf("got %d documents, want 1", len(docs))
+	}
+
+	var doc struct {
+		Swagger     string                     `json:"swagger"`
+		Definitions map[string]json.RawMessage `json:"definitions"`
+	}
+	if err := json.Unmarshal(docs[0], &doc); err != nil {
+		t.Fatalf("unmarshaling synthesized document: %v", err)
+	}
+	if doc.Swagger != "2.0" {
+		t.Errorf("swagger = %q, want %q", doc.Swagger, "2.0")
+	}
+	const wantKey = "example.com.v1.Widget"
+	if _, ok := doc.Definitions[wantKey]; !ok {
+		t.Errorf("definitions missing key %q, got %v", wantKey, doc.Definitions)
+	}
+}
+
+func TestClusterSpecLiveCRDSchemasSkipsVersionsWithoutSchema(t *testing.T) {
+	crd := &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: "widgets.example.com"},
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Group: "example.com",
+			Names: apiextensionsv1.CustomResourceDefinitionNames{Kind: "Widget"},
+			Versions: []apiextensionsv1.CustomResourceDefinitionVersion{
+				{Name: "v1"},
+			},
+		},
+	}
+
+	cs := &clusterSpecLive{crdClientOverride: apiextensionsfake.NewSimpleClientset(crd)}
+
+	docs, err := cs.CRDSchemas()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(docs) != 0 {
+		t.Errorf("got %d documents, want 0 when no version has a schema", len(docs))
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2017 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lib
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/spf13/afero"
+)
+
+// clusterSpecRegistry resolves a ClusterSpec from a pre-packaged OpenAPI
+// bundle published as an OCI artifact, e.g. a composite document combining
+// the base Kubernetes schema with a team's internal CRDs. This lets teams
+// publish and version their own schemas instead of pointing at raw GitHub
+// URLs.
+type clusterSpecRegistry struct {
+	ref string
+	fs  afero.Fs
+}
+
+func (cs *clusterSpecRegistry) Resource() string {
+	return cs.ref
+}
+
+// CRDSchemas always returns no schemas: a registry bundle is expected to
+// already be a composite document, so there is nothing further to merge.
+func (cs *clusterSpecRegistry) CRDSchemas() ([][]byte, error) {
+	return nil, nil
+}
+
+func (cs *clusterSpecRegistry) OpenAPI() ([]byte, error) {
+	ref, err := name.ParseReference(cs.ref)
+	if err != nil {
+		return nil, fmt.Errorf("parsing registry reference '%s': %v", cs.ref, err)
+	}
+
+	img, err := remote.Image(ref)
+	if err != nil {
+		return nil, fmt.Errorf("pulling OpenAPI bundle from '%s': %v", cs.ref, err)
+	}
+
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, fmt.Errorf("reading layers of OpenAPI bundle '%s': %v", cs.ref, err)
+	}
+
+	for _, layer := range layers {
+		bundle, err := extractOpenAPIBundle(layer)
+		if err != nil {
+			return nil, err
+		}
+		if bundle != nil {
+			return bundle, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no OpenAPI bundle (*.json) found in registry artifact '%s'", cs.ref)
+}
+
+// extractOpenAPIBundle reads a single image layer as a tarball and returns
+// the contents of the first `.json` file it finds, or nil if the layer
+// contains no such file.
+func extractOpenAPIBundle(layer interface {
+	Uncompressed() (io.ReadCloser, error)
+}) ([]byte, error) {
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		return nil, fmt.Errorf("reading registry layer: %v", err)
+	}
+	defer rc.Close()
+
+	tr := tar.NewReader(rc)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading registry layer tarball: %v", err)
+		}
+
+		if header.Typeflag != tar.TypeReg || !strings.HasSuffix(header.Name, ".json") {
+			continue
+		}
+
+		return io.ReadAll(tr)
+	}
+}
+
+func (cs *clusterSpecRegistry) Version() (string, error) {
+	bundle, err := cs.OpenAPI()
+	if err != nil {
+		return "", err
+	}
+
+	var spec struct {
+		Info struct {
+			Version string `json:"version"`
+		} `json:"info"`
+	}
+	if err := json.Unmarshal(bundle, &spec); err != nil {
+		return "", fmt.Errorf("parsing OpenAPI bundle from '%s': %v", cs.ref, err)
+	}
+
+	return spec.Info.Version, nil
+}
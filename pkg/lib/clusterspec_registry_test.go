@@ -0,0 +1,89 @@
+// Copyright 2017 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// fakeLayer implements the minimal `Uncompressed() (io.ReadCloser, error)`
+// shape extractOpenAPIBundle needs, without depending on a real
+// v1.Layer/registry pull.
+type fakeLayer struct {
+	tarball []byte
+}
+
+func (f fakeLayer) Uncompressed() (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(f.tarball)), nil
+}
+
+func buildTarball(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range files {
+		hdr := &tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("writing tar header for %q: %v", name, err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatalf("writing tar content for %q: %v", name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("closing tar writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractOpenAPIBundleFindsJSONFile(t *testing.T) {
+	tarball := buildTarball(t, map[string]string{
+		"README.md":    "not the bundle",
+		"openapi.json": `{"info":{"version":"v1.2.3"}}`,
+	})
+
+	bundle, err := extractOpenAPIBundle(fakeLayer{tarball: tarball})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(bundle) != `{"info":{"version":"v1.2.3"}}` {
+		t.Errorf("bundle = %q, want the contents of openapi.json", bundle)
+	}
+}
+
+func TestExtractOpenAPIBundleNoJSONFile(t *testing.T) {
+	tarball := buildTarball(t, map[string]string{
+		"README.md": "not the bundle",
+	})
+
+	bundle, err := extractOpenAPIBundle(fakeLayer{tarball: tarball})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bundle != nil {
+		t.Errorf("bundle = %q, want nil when the layer has no .json file", bundle)
+	}
+}
@@ -0,0 +1,377 @@
+// Copyright 2017 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+	"k8s.io/client-go/util/homedir"
+)
+
+// errSchemaNotFound signals that a source simply doesn't have this schema
+// version (e.g. a 404) -- as opposed to a network/IO error, which should be
+// retried and, if every attempt fails, reported rather than silently
+// swallowed.
+var errSchemaNotFound = errors.New("schema source does not have this version")
+
+const (
+	k8sVersionURLTemplate = "https://raw.githubusercontent.com/kubernetes/kubernetes/%s/api/openapi-spec/swagger.json"
+
+	// openAPICacheTTL bounds how long a cached swagger.json is trusted before
+	// it is unconditionally refetched, even if the origin never returns a
+	// fresh ETag/Last-Modified pair.
+	openAPICacheTTL = 7 * 24 * time.Hour
+
+	// schemaSourceMaxAttempts bounds the retries a single schema source gets
+	// on a 5xx or network error before giving up and letting the resolver
+	// move on to the next source.
+	schemaSourceMaxAttempts = 3
+	schemaSourceBaseBackoff = 250 * time.Millisecond
+)
+
+// schemaSource knows how to fetch the OpenAPI schema for a given k8s version
+// from one particular place. A nil, nil return means the source simply
+// doesn't have this version (e.g. a 404), so the resolver should move on to
+// the next source or the release-version fallback without treating it as an
+// error.
+type schemaSource interface {
+	// Name identifies the source for error reporting.
+	Name() string
+	Fetch(client *http.Client, fs afero.Fs, version string, refresh bool) ([]byte, error)
+}
+
+// githubRawSource is the original, and default, source: the swagger.json
+// published alongside each Kubernetes release on GitHub.
+type githubRawSource struct{}
+
+func (githubRawSource) Name() string { return "github" }
+
+func (githubRawSource) Fetch(client *http.Client, fs afero.Fs, version string, refresh bool) ([]byte, error) {
+	return fetchHTTPSchema(client, fs, fmt.Sprintf(k8sVersionURLTemplate, version), refresh)
+}
+
+// httpMirrorSource fetches from a user-supplied HTTP(S) mirror, addressed as
+// `<baseURL>/<version>/swagger.json`.
+type httpMirrorSource struct {
+	baseURL string
+}
+
+func (s httpMirrorSource) Name() string { return s.baseURL }
+
+func (s httpMirrorSource) Fetch(client *http.Client, fs afero.Fs, version string, refresh bool) ([]byte, error) {
+	url := strings.TrimSuffix(s.baseURL, "/") + "/" + version + "/swagger.json"
+	return fetchHTTPSchema(client, fs, url, refresh)
+}
+
+// localDirSource reads a pre-downloaded `<version>.json` out of a local
+// directory, e.g. for air-gapped installs seeded out of band.
+type localDirSource struct {
+	dir string
+	fs  afero.Fs
+}
+
+func (s localDirSource) Name() string { return "file://" + s.dir }
+
+func (s localDirSource) Fetch(client *http.Client, fs afero.Fs, version string, refresh bool) ([]byte, error) {
+	schema, err := afero.ReadFile(s.fs, filepath.Join(s.dir, version+".json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return schema, err
+}
+
+// parseVersionSpec splits a `version:<k8sVersion>[@mirror=<url>[,<url>...]][#sha256=<hex>]`
+// spec value into its components. Mirrors and the checksum are both optional.
+func parseVersionSpec(value string) (version, mirrors, checksum string) {
+	version = value
+	if idx := strings.Index(version, "#sha256="); idx != -1 {
+		checksum = version[idx+len("#sha256="):]
+		version = version[:idx]
+	}
+	if idx := strings.Index(version, "@mirror="); idx != -1 {
+		mirrors = version[idx+len("@mirror="):]
+		version = version[:idx]
+	}
+	return version, mirrors, checksum
+}
+
+// schemaResolver tries a list of schemaSources, in order, to find the OpenAPI
+// schema for a k8s version, falling back to the release-X.Y schema when the
+// exact version isn't published anywhere, and optionally verifying a
+// SHA-256 checksum of whatever bytes it finds.
+type schemaResolver struct {
+	sources  []schemaSource
+	checksum string
+}
+
+// newSchemaResolver builds a resolver from a comma-separated mirror list
+// (HTTP(S) URLs or `file://` directories), tried before the GitHub raw
+// source, and an optional expected SHA-256 checksum.
+func newSchemaResolver(mirrors string, fs afero.Fs, checksum string) *schemaResolver {
+	var sources []schemaSource
+	for _, m := range strings.Split(mirrors, ",") {
+		m = strings.TrimSpace(m)
+		if m == "" {
+			continue
+		}
+		if strings.HasPrefix(m, "file://") {
+			sources = append(sources, localDirSource{dir: strings.TrimPrefix(m, "file://"), fs: fs})
+		} else {
+			sources = append(sources, httpMirrorSource{baseURL: m})
+		}
+	}
+	sources = append(sources, githubRawSource{})
+
+	return &schemaResolver{sources: sources, checksum: strings.ToLower(checksum)}
+}
+
+// Resolve fetches the schema for version, falling back to the release-X.Y
+// schema (e.g. `release-1.11` for `v1.11.7`) if no source has the exact
+// version.
+func (r *schemaResolver) Resolve(client *http.Client, fs afero.Fs, version string, refresh bool) ([]byte, error) {
+	schema, errs := r.fetch(client, fs, version, refresh)
+	if schema != nil {
+		return schema, nil
+	}
+
+	segments := strings.Split(strings.Replace(version, "v", "", 1), ".")
+	if len(segments) < 2 {
+		return nil, fmt.Errorf("unrecognizable k8s version '%s'", version)
+	}
+	releaseVersion := "release-" + strings.Join(segments[0:2], ".")
+
+	releaseSchema, releaseErrs := r.fetch(client, fs, releaseVersion, refresh)
+	if releaseSchema != nil {
+		return releaseSchema, nil
+	}
+
+	errs = append(errs, releaseErrs...)
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("unable to fetch OpenAPI schema for version '%s' (also tried '%s')", version, releaseVersion)
+	}
+	return nil, fmt.Errorf("unable to fetch OpenAPI schema for version '%s' (also tried '%s'): %s",
+		version, releaseVersion, strings.Join(errs, "; "))
+}
+
+// fetch tries every source in order for a single version string, returning
+// the first usable schema along with the per-source failures seen along the
+// way (useful for error reporting even when a later source succeeds... and
+// essential when none do).
+func (r *schemaResolver) fetch(client *http.Client, fs afero.Fs, version string, refresh bool) ([]byte, []string) {
+	var errs []string
+	for _, src := range r.sources {
+		schema, err := src.Fetch(client, fs, version, refresh)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", src.Name(), err))
+			continue
+		}
+		if schema == nil {
+			continue
+		}
+
+		if r.checksum != "" {
+			if err := verifyChecksum(schema, r.checksum); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", src.Name(), err))
+				continue
+			}
+		}
+
+		return schema, errs
+	}
+	return nil, errs
+}
+
+func verifyChecksum(data []byte, expectedHex string) error {
+	sum := sha256.Sum256(data)
+	got := hex.EncodeToString(sum[:])
+	if !strings.EqualFold(got, expectedHex) {
+		return fmt.Errorf("checksum mismatch: expected sha256:%s, got sha256:%s", expectedHex, got)
+	}
+	return nil
+}
+
+// openAPICacheMeta is persisted alongside a cached swagger.json so later
+// calls can issue a conditional GET instead of re-downloading the body.
+type openAPICacheMeta struct {
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"lastModified,omitempty"`
+	FetchedAt    time.Time `json:"fetchedAt"`
+}
+
+// openAPICacheDir returns $XDG_CACHE_HOME/ksonnet/openapi, falling back to
+// ~/.cache/ksonnet/openapi when XDG_CACHE_HOME is unset.
+func openAPICacheDir() string {
+	cacheHome := os.Getenv("XDG_CACHE_HOME")
+	if cacheHome == "" {
+		cacheHome = filepath.Join(homedir.HomeDir(), ".cache")
+	}
+	return filepath.Join(cacheHome, "ksonnet", "openapi")
+}
+
+// schemaCacheKey content-addresses a cache entry by the URL it was fetched
+// from, so distinct sources (or mirrors) never collide on disk.
+func schemaCacheKey(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:])
+}
+
+func schemaCachePaths(url string) (schemaPath, metaPath string) {
+	dir := openAPICacheDir()
+	key := schemaCacheKey(url)
+	return filepath.Join(dir, key+".json"), filepath.Join(dir, key+".meta.json")
+}
+
+func readSchemaCacheMeta(fs afero.Fs, metaPath string) *openAPICacheMeta {
+	if fs == nil {
+		return nil
+	}
+	bytes, err := afero.ReadFile(fs, metaPath)
+	if err != nil {
+		return nil
+	}
+	var meta openAPICacheMeta
+	if err := json.Unmarshal(bytes, &meta); err != nil {
+		return nil
+	}
+	return &meta
+}
+
+func writeSchemaCache(fs afero.Fs, schemaPath, metaPath string, body []byte, meta openAPICacheMeta) {
+	if fs == nil {
+		return
+	}
+	if err := fs.MkdirAll(filepath.Dir(schemaPath), 0755); err != nil {
+		log.Warningf("could not create OpenAPI cache directory: %v", err)
+		return
+	}
+	if err := afero.WriteFile(fs, schemaPath, body, 0644); err != nil {
+		log.Warningf("could not write OpenAPI cache entry '%s': %v", schemaPath, err)
+		return
+	}
+	metaBytes, err := json.Marshal(meta)
+	if err != nil {
+		return
+	}
+	_ = afero.WriteFile(fs, metaPath, metaBytes, 0644)
+}
+
+// fetchHTTPSchema fetches url with an on-disk, ETag-aware cache and
+// exponential backoff retries on 5xx/network errors. A non-5xx error status
+// (e.g. 404) is treated as "this source doesn't have it" and returns
+// (nil, nil), matching the convention the resolver's release-version
+// fallback relies on.
+func fetchHTTPSchema(client *http.Client, fs afero.Fs, url string, refresh bool) ([]byte, error) {
+	schemaPath, metaPath := schemaCachePaths(url)
+
+	var meta *openAPICacheMeta
+	if !refresh {
+		meta = readSchemaCacheMeta(fs, metaPath)
+	}
+
+	var lastErr error
+	backoff := schemaSourceBaseBackoff
+	for attempt := 0; attempt < schemaSourceMaxAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		if meta != nil && time.Since(meta.FetchedAt) < openAPICacheTTL {
+			if meta.ETag != "" {
+				req.Header.Set("If-None-Match", meta.ETag)
+			}
+			if meta.LastModified != "" {
+				req.Header.Set("If-Modified-Since", meta.LastModified)
+			}
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		body, err := readAndClassifyResponse(resp, fs, schemaPath, metaPath, url)
+		resp.Body.Close()
+		if err != nil {
+			if errors.Is(err, errSchemaNotFound) {
+				return nil, nil
+			}
+			// A 5xx, a network-level body read failure, or a 304 with no
+			// cached copy to fall back on -- all worth retrying/reporting,
+			// unlike a plain "not found".
+			lastErr = err
+			continue
+		}
+		return body, nil
+	}
+
+	return nil, fmt.Errorf("giving up after %d attempts against '%s': %v", schemaSourceMaxAttempts, url, lastErr)
+}
+
+// readAndClassifyResponse turns an HTTP response into schema bytes, handling
+// the 304/200/other-status cases and updating the on-disk cache. It returns
+// errSchemaNotFound when the source plainly doesn't have this version (a
+// non-5xx, non-304 status), and any other error for failures -- a 5xx, a
+// 304 with nothing cached, or an I/O error reading the body -- that the
+// caller should retry and ultimately surface rather than treat as a miss.
+func readAndClassifyResponse(resp *http.Response, fs afero.Fs, schemaPath, metaPath, url string) ([]byte, error) {
+	if resp.StatusCode == http.StatusNotModified {
+		if cached, err := afero.ReadFile(fs, schemaPath); err == nil {
+			return cached, nil
+		}
+		log.Warningf("received 304 for '%s' but no cached copy was found at '%s'", url, schemaPath)
+		return nil, fmt.Errorf("no cached copy available for 304 response from '%s'", url)
+	}
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil, fmt.Errorf("received status code '%d' from '%s'", resp.StatusCode, url)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		log.Warningf("received status code '%d' when attempting to retrieve OpenAPI schema from '%s'", resp.StatusCode, url)
+		return nil, errSchemaNotFound
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading response body from '%s': %v", url, err)
+	}
+
+	writeSchemaCache(fs, schemaPath, metaPath, body, openAPICacheMeta{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		FetchedAt:    time.Now(),
+	})
+
+	return body, nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2017 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lib
+
+import "testing"
+
+func TestParseVersionSpec(t *testing.T) {
+	tests := []struct {
+		name         string
+		value        string
+		wantVersion  string
+		wantMirrors  string
+		wantChecksum string
+	}{
+		{
+			name:        "version only",
+			value:       "v1.21.0",
+			wantVersion: "v1.21.0",
+		},
+		{
+			name:        "version with mirrors",
+			value:       "v1.21.0@mirror=https://my.corp/k8s-openapi/,https://raw.githubusercontent.com/foo",
+			wantVersion: "v1.21.0",
+			wantMirrors: "https://my.corp/k8s-openapi/,https://raw.githubusercontent.com/foo",
+		},
+		{
+			name:         "version with checksum",
+			value:        "v1.21.0#sha256=deadbeef",
+			wantVersion:  "v1.21.0",
+			wantChecksum: "deadbeef",
+		},
+		{
+			name:         "version with mirrors and checksum",
+			value:        "v1.21.0@mirror=https://my.corp/k8s-openapi/#sha256=deadbeef",
+			wantVersion:  "v1.21.0",
+			wantMirrors:  "https://my.corp/k8s-openapi/",
+			wantChecksum: "deadbeef",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			version, mirrors, checksum := parseVersionSpec(tc.value)
+			if version != tc.wantVersion {
+				t.Errorf("version = %q, want %q", version, tc.wantVersion)
+			}
+			if mirrors != tc.wantMirrors {
+				t.Errorf("mirrors = %q, want %q", mirrors, tc.wantMirrors)
+			}
+			if checksum != tc.wantChecksum {
+				t.Errorf("checksum = %q, want %q", checksum, tc.wantChecksum)
+			}
+		})
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	data := []byte("swagger-content")
+	// sha256("swagger-content")
+	const wantSum = "519e60b8a2a3811c290653e69051acfe16e5139d2ecadf32576a36cebdf7391f"
+
+	if err := verifyChecksum(data, wantSum); err != nil {
+		t.Errorf("verifyChecksum with correct sum returned error: %v", err)
+	}
+
+	// Checksums should be compared case-insensitively.
+	if err := verifyChecksum(data, "519E60B8A2A3811C290653E69051ACFE16E5139D2ECADF32576A36CEBDF7391F"); err != nil {
+		t.Errorf("verifyChecksum should be case-insensitive: %v", err)
+	}
+
+	if err := verifyChecksum(data, "0000000000000000000000000000000000000000000000000000000000000000"); err == nil {
+		t.Error("verifyChecksum with a wrong sum should return an error")
+	}
+}
+
+func TestSchemaCacheKeyIsStableAndDistinctPerURL(t *testing.T) {
+	keyA1 := schemaCacheKey("https://raw.githubusercontent.com/kubernetes/kubernetes/v1.21.0/api/openapi-spec/swagger.json")
+	keyA2 := schemaCacheKey("https://raw.githubusercontent.com/kubernetes/kubernetes/v1.21.0/api/openapi-spec/swagger.json")
+	keyB := schemaCacheKey("https://my.corp/k8s-openapi/v1.21.0/swagger.json")
+
+	if keyA1 != keyA2 {
+		t.Errorf("schemaCacheKey should be deterministic, got %q and %q for the same URL", keyA1, keyA2)
+	}
+	if keyA1 == keyB {
+		t.Errorf("schemaCacheKey should differ for different URLs, both produced %q", keyA1)
+	}
+}
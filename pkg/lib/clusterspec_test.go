@@ -0,0 +1,71 @@
+// Copyright 2017 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lib
+
+import (
+	"path/filepath"
+	"testing"
+
+	"k8s.io/client-go/util/homedir"
+)
+
+func TestParseKubeconfigSpec(t *testing.T) {
+	defaultPath := filepath.Join(homedir.HomeDir(), ".kube", "config")
+
+	tests := []struct {
+		name            string
+		value           string
+		wantPath        string
+		wantKubeContext string
+	}{
+		{
+			name:            "path only",
+			value:           "/home/user/.kube/config",
+			wantPath:        "/home/user/.kube/config",
+			wantKubeContext: "",
+		},
+		{
+			name:            "path and context",
+			value:           "/home/user/.kube/config@staging",
+			wantPath:        "/home/user/.kube/config",
+			wantKubeContext: "staging",
+		},
+		{
+			name:            "empty value defaults to ~/.kube/config",
+			value:           "",
+			wantPath:        defaultPath,
+			wantKubeContext: "",
+		},
+		{
+			name:            "context only, no path",
+			value:           "@staging",
+			wantPath:        defaultPath,
+			wantKubeContext: "staging",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path, kubeContext := parseKubeconfigSpec(tc.value)
+			if path != tc.wantPath {
+				t.Errorf("path = %q, want %q", path, tc.wantPath)
+			}
+			if kubeContext != tc.wantKubeContext {
+				t.Errorf("kubeContext = %q, want %q", kubeContext, tc.wantKubeContext)
+			}
+		})
+	}
+}
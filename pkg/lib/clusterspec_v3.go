@@ -0,0 +1,319 @@
+// Copyright 2017 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// openAPIV3Index is the document served at `/openapi/v3`, mapping each
+// group-version's path (e.g. `api/v1`, `apis/apps/v1`) to where its document
+// can be fetched.
+type openAPIV3Index struct {
+	Paths map[string]struct {
+		ServerRelativeURL string `json:"serverRelativeURL"`
+	} `json:"paths"`
+}
+
+// openAPIV3Document is the minimal shape of a per-group-version OpenAPI v3
+// document that clusterSpecV3 cares about: its schemas, which are merged into
+// a single combined document. Paths are intentionally not modeled here -- the
+// merged document is reshaped into the same swagger 2.0 `definitions` form
+// clusterSpecFile/clusterSpecVersion produce, which has no notion of paths.
+type openAPIV3Document struct {
+	Components struct {
+		Schemas map[string]json.RawMessage `json:"schemas"`
+	} `json:"components"`
+}
+
+// clusterSpecV3 resolves a ClusterSpec from the Kubernetes OpenAPI v3
+// aggregated discovery document (`/openapi/v3`), merging the per-group-version
+// documents it references into a single combined document. It can either talk
+// to a live API server (serverURL set) or replay a previously cached merge for
+// a known k8s version (k8sVersion set).
+type clusterSpecV3 struct {
+	serverURL  string
+	k8sVersion string
+	httpClient *http.Client
+	fs         afero.Fs
+}
+
+func (cs *clusterSpecV3) Resource() string {
+	if cs.serverURL != "" {
+		return cs.serverURL
+	}
+	return cs.k8sVersion
+}
+
+// CRDSchemas always returns no schemas: the v3 aggregated discovery document
+// already describes the built-in API surface, and CRD extraction is handled
+// by the `kubeconfig:`/`url:` live-cluster spec instead.
+func (cs *clusterSpecV3) CRDSchemas() ([][]byte, error) {
+	return nil, nil
+}
+
+func (cs *clusterSpecV3) Version() (string, error) {
+	if cs.serverURL != "" {
+		return cs.fetchServerVersion()
+	}
+
+	doc, err := cs.loadCachedMerge(cs.k8sVersion)
+	if err != nil {
+		return "", err
+	}
+
+	var info struct {
+		Info struct {
+			Version string `json:"version"`
+		} `json:"info"`
+	}
+	if err := json.Unmarshal(doc, &info); err != nil {
+		return "", fmt.Errorf("parsing cached OpenAPI v3 document: %v", err)
+	}
+	return info.Info.Version, nil
+}
+
+func (cs *clusterSpecV3) OpenAPI() ([]byte, error) {
+	if cs.serverURL == "" {
+		return cs.loadCachedMerge(cs.k8sVersion)
+	}
+
+	index, err := cs.fetchIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	cacheKey := indexCacheKey(index)
+	if cached, err := cs.readCache(cacheKey); err == nil {
+		return cached, nil
+	}
+
+	// Best-effort: the merged document's info.version is what a later
+	// `v3:<version>` spec (no serverURL) will read back via Version(), so we
+	// resolve it before merging rather than leaving it blank.
+	version, err := cs.fetchServerVersion()
+	if err != nil {
+		log.Warningf("could not determine live cluster version while merging OpenAPI v3 schema: %v", err)
+	}
+
+	merged, err := cs.fetchAndMerge(index, version)
+	if err != nil {
+		return nil, err
+	}
+
+	// A merge keyed by content hash is always written, and if we know the
+	// live server's version we also leave a copy keyed by that version so a
+	// later `v3:<version>` spec can replay it offline.
+	cs.writeCache(cacheKey, merged)
+	if version != "" {
+		cs.writeCache(version, merged)
+	}
+
+	return merged, nil
+}
+
+func (cs *clusterSpecV3) fetchServerVersion() (string, error) {
+	resp, err := cs.httpClient.Get(strings.TrimSuffix(cs.serverURL, "/") + "/version")
+	if err != nil {
+		return "", fmt.Errorf("fetching server version: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("received status code '%d' when fetching server version", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	var info struct {
+		GitVersion string `json:"gitVersion"`
+	}
+	if err := json.Unmarshal(body, &info); err != nil {
+		return "", fmt.Errorf("parsing server version response: %v", err)
+	}
+
+	return info.GitVersion, nil
+}
+
+func (cs *clusterSpecV3) fetchIndex() (*openAPIV3Index, error) {
+	indexURL := strings.TrimSuffix(cs.serverURL, "/") + "/openapi/v3"
+	resp, err := cs.httpClient.Get(indexURL)
+	if err != nil {
+		return nil, fmt.Errorf("fetching OpenAPI v3 index: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("received status code '%d' when fetching OpenAPI v3 index from '%s'", resp.StatusCode, indexURL)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var index openAPIV3Index
+	if err := json.Unmarshal(body, &index); err != nil {
+		return nil, fmt.Errorf("parsing OpenAPI v3 index: %v", err)
+	}
+
+	return &index, nil
+}
+
+// fetchAndMerge resolves every group-version document referenced by the
+// index relative to the configured server URL -- not the raw request URI, so
+// that a client-side URL prefix (e.g. an `kubectl proxy` path) is preserved --
+// and merges their schemas into a single combined document, reshaped as
+// swagger 2.0 `definitions` (like the v2 swagger.json clusterSpecFile and
+// clusterSpecVersion produce) and stamped with the given version (the live
+// server's GitVersion, or "" if it couldn't be determined).
+func (cs *clusterSpecV3) fetchAndMerge(index *openAPIV3Index, version string) ([]byte, error) {
+	base, err := url.Parse(cs.serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing server URL '%s': %v", cs.serverURL, err)
+	}
+
+	schemas := map[string]json.RawMessage{}
+	for gv, entry := range index.Paths {
+		docURL, err := resolveServerRelativeURL(base, entry.ServerRelativeURL)
+		if err != nil {
+			return nil, fmt.Errorf("resolving OpenAPI v3 document URL for '%s': %v", gv, err)
+		}
+
+		doc, err := cs.fetchDocument(docURL)
+		if err != nil {
+			return nil, fmt.Errorf("fetching OpenAPI v3 document for '%s': %v", gv, err)
+		}
+
+		for name, schema := range doc.Components.Schemas {
+			schemas[name] = schema
+		}
+	}
+
+	merged := map[string]interface{}{
+		"swagger":     "2.0",
+		"info":        map[string]string{"title": "Kubernetes", "version": version},
+		"definitions": schemas,
+	}
+	return json.Marshal(merged)
+}
+
+func (cs *clusterSpecV3) fetchDocument(docURL string) (*openAPIV3Document, error) {
+	resp, err := cs.httpClient.Get(docURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("received status code '%d' from '%s'", resp.StatusCode, docURL)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc openAPIV3Document
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+
+	return &doc, nil
+}
+
+// resolveServerRelativeURL resolves a `serverRelativeURL` from the v3 index
+// (which already embeds a content hash, e.g. `/openapi/v3/api/v1?hash=...`)
+// against the configured server, keeping any scheme/host/path prefix the
+// caller supplied rather than the bare request URI.
+func resolveServerRelativeURL(base *url.URL, serverRelativeURL string) (string, error) {
+	rel, err := url.Parse(serverRelativeURL)
+	if err != nil {
+		return "", err
+	}
+
+	resolved := *base
+	resolved.Path = path.Join(base.Path, rel.Path)
+	resolved.RawQuery = rel.RawQuery
+	return resolved.String(), nil
+}
+
+// indexCacheKey derives a content-addressed cache key from the set of
+// serverRelativeURL hashes in the index, so that a merge is only ever
+// recomputed when the underlying schemas actually change.
+func indexCacheKey(index *openAPIV3Index) string {
+	urls := make([]string, 0, len(index.Paths))
+	for _, entry := range index.Paths {
+		urls = append(urls, entry.ServerRelativeURL)
+	}
+	sort.Strings(urls)
+
+	h := sha256.New()
+	for _, u := range urls {
+		h.Write([]byte(u))
+		h.Write([]byte("\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cacheDir shares the same root as clusterSpecVersion's swagger.json cache
+// ($XDG_CACHE_HOME/ksonnet/openapi), under a v3-specific subdirectory so
+// merges never collide with v2 cache entries.
+func (cs *clusterSpecV3) cacheDir() string {
+	return filepath.Join(openAPICacheDir(), "v3")
+}
+
+func (cs *clusterSpecV3) readCache(key string) ([]byte, error) {
+	return afero.ReadFile(cs.fs, filepath.Join(cs.cacheDir(), key+".json"))
+}
+
+func (cs *clusterSpecV3) writeCache(key string, data []byte) {
+	if cs.fs == nil || key == "" {
+		return
+	}
+	if err := cs.fs.MkdirAll(cs.cacheDir(), 0755); err != nil {
+		return
+	}
+	_ = afero.WriteFile(cs.fs, filepath.Join(cs.cacheDir(), key+".json"), data, 0644)
+}
+
+func (cs *clusterSpecV3) loadCachedMerge(version string) ([]byte, error) {
+	if version == "" {
+		return nil, fmt.Errorf("no k8s version or server URL was provided for the OpenAPI v3 cluster spec")
+	}
+
+	doc, err := cs.readCache(version)
+	if err != nil {
+		return nil, fmt.Errorf("no cached OpenAPI v3 document for version '%s'; fetch it from a live cluster first with a 'v3url:' spec", version)
+	}
+	return doc, nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2017 The ksonnet authors
+//
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package lib
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestResolveServerRelativeURLPreservesPrefix(t *testing.T) {
+	tests := []struct {
+		name              string
+		base              string
+		serverRelativeURL string
+		want              string
+	}{
+		{
+			name:              "bare server",
+			base:              "https://cluster.example.com",
+			serverRelativeURL: "/openapi/v3/api/v1?hash=abc123",
+			want:              "https://cluster.example.com/openapi/v3/api/v1?hash=abc123",
+		},
+		{
+			name:              "server with a client-side URL prefix, e.g. kubectl proxy",
+			base:              "https://proxy.example.com/k8s/clusters/my-cluster",
+			serverRelativeURL: "/openapi/v3/apis/apps/v1?hash=def456",
+			want:              "https://proxy.example.com/k8s/clusters/my-cluster/openapi/v3/apis/apps/v1?hash=def456",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			base, err := url.Parse(tc.base)
+			if err != nil {
+				t.Fatalf("parsing base URL: %v", err)
+			}
+
+			got, err := resolveServerRelativeURL(base, tc.serverRelativeURL)
+			if err != nil {
+				t.Fatalf("resolveServerRelativeURL returned error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("resolveServerRelativeURL() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestIndexCacheKeyIsStableAndContentAddressed(t *testing.T) {
+	index1 := &openAPIV3Index{Paths: map[string]struct {
+		ServerRelativeURL string `json:"serverRelativeURL"`
+	}{
+		"api/v1":       {ServerRelativeURL: "/openapi/v3/api/v1?hash=aaa"},
+		"apis/apps/v1": {ServerRelativeURL: "/openapi/v3/apis/apps/v1?hash=bbb"},
+	}}
+
+	// Same content, different map iteration order: same key.
+	index2 := &openAPIV3Index{Paths: map[string]struct {
+		ServerRelativeURL string `json:"serverRelativeURL"`
+	}{
+		"apis/apps/v1": {ServerRelativeURL: "/openapi/v3/apis/apps/v1?hash=bbb"},
+		"api/v1":       {ServerRelativeURL: "/openapi/v3/api/v1?hash=aaa"},
+	}}
+
+	key1 := indexCacheKey(index1)
+	key2 := indexCacheKey(index2)
+	if key1 != key2 {
+		t.Errorf("indexCacheKey should not depend on map iteration order: %q != %q", key1, key2)
+	}
+
+	// A changed hash produces a different key.
+	index3 := &openAPIV3Index{Paths: map[string]struct {
+		ServerRelativeURL string `json:"serverRelativeURL"`
+	}{
+		"api/v1":       {ServerRelativeURL: "/openapi/v3/api/v1?hash=ccc"},
+		"apis/apps/v1": {ServerRelativeURL: "/openapi/v3/apis/apps/v1?hash=bbb"},
+	}}
+	key3 := indexCacheKey(index3)
+	if key1 == key3 {
+		t.Errorf("indexCacheKey should change when a referenced document's hash changes")
+	}
+}